@@ -0,0 +1,227 @@
+// Command batsd-rump migrates Redis-resident recent series into on-disk v2
+// files, in the style of RedisShake's rump mode. It SCANs the keyspace for
+// counters:*, timers:* and gauges:* zsets and rewrites each one into the file
+// shared.CalculateFilename would place it in, letting an operator promote
+// series into the archival tier ahead of schedule, evacuate a Redis instance
+// before decommissioning it, or seed a fresh disk store from a running Redis.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"../../shared"
+	"github.com/go-redis/redis/v8"
+)
+
+var ctx = context.Background()
+
+var patterns = []string{"counters:*", "timers:*", "gauges:*"}
+
+const defaultScanBatchSize = 100
+const defaultCheckpointFile = "batsd-rump.cursor"
+const defaultFailuresFile = "batsd-rump.failures"
+const zsetChunkSize = 500
+
+// checkpoint records resumable progress: which of patterns is in flight, the
+// cursor SCAN returned for the page currently being worked through, and the
+// keys from that page not yet fully migrated. PendingKeys is saved after
+// every single key completes, so a crash only replays the one key in flight,
+// not the whole page.
+type checkpoint struct {
+	PatternIndex int
+	Cursor       uint64
+	PendingKeys  []string
+	// PatternScanned is true once at least one SCAN call has been made for
+	// the current pattern, so a Cursor of 0 can be told apart from "haven't
+	// scanned yet" (a pattern's first SCAN call is also made with cursor 0).
+	PatternScanned bool
+}
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "log what would be migrated without touching disk")
+	scanBatchSize := flag.Int64("scan-batch-size", defaultScanBatchSize, "SCAN COUNT per iteration")
+	keysPerSecond := flag.Int("rate", 0, "maximum keys migrated per second, 0 for unlimited")
+	checkpointPath := flag.String("checkpoint", defaultCheckpointFile, "checkpoint file, used to resume a prior run")
+	failuresPath := flag.String("failures", defaultFailuresFile, "file that keys failing to migrate are recorded to")
+	flag.Parse()
+
+	shared.LoadConfig()
+
+	client, err := shared.NewRedisClient(shared.Config.RedisURL)
+	if err != nil {
+		panic(err)
+	}
+	defer client.Close()
+
+	cp := loadCheckpoint(*checkpointPath)
+	throttle := newRateLimiter(*keysPerSecond)
+	failures := 0
+
+	for cp.PatternIndex < len(patterns) {
+		if len(cp.PendingKeys) == 0 {
+			if cp.Cursor == 0 && cp.PatternScanned {
+				cp.PatternIndex++
+				cp.PatternScanned = false
+				saveCheckpoint(*checkpointPath, cp)
+				continue
+			}
+
+			pattern := patterns[cp.PatternIndex]
+			keys, next, err := client.Scan(ctx, cp.Cursor, pattern, *scanBatchSize).Result()
+			if err != nil {
+				panic(err)
+			}
+
+			cp.Cursor = next
+			cp.PendingKeys = keys
+			cp.PatternScanned = true
+			saveCheckpoint(*checkpointPath, cp)
+			continue
+		}
+
+		key := cp.PendingKeys[0]
+		throttle()
+		if err := migrateKey(client, key, *dryRun); err != nil {
+			failures++
+			fmt.Printf("ERROR: failed to migrate %v: %v\n", key, err)
+			recordFailure(*failuresPath, key, err)
+		}
+
+		cp.PendingKeys = cp.PendingKeys[1:]
+		saveCheckpoint(*checkpointPath, cp)
+	}
+
+	if failures > 0 {
+		fmt.Printf("rump migration finished with %d failed key(s), see %v\n", failures, *failuresPath)
+		os.Exit(1)
+	}
+	fmt.Println("rump migration complete")
+}
+
+// migrateKey reads key's full zset with ZRANGEBYSCORE in zsetChunkSize pages,
+// buffers it into one set of "timestamp value" lines, and appends it to the
+// on-disk v2 file shared.CalculateFilename computes for it in a single write,
+// writing the v2 header first if the file doesn't exist yet. Buffering the
+// whole key before writing keeps a crash mid-migration from leaving a
+// half-written line in the target file.
+func migrateKey(client redis.UniversalClient, key string, dryRun bool) error {
+	var lines strings.Builder
+
+	var offset int64
+	for {
+		entries, err := client.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+			Min:    "-inf",
+			Max:    "+inf",
+			Offset: offset,
+			Count:  zsetChunkSize,
+		}).Result()
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			content, _ := entry.Member.(string)
+			value := content
+			if parts := strings.SplitN(content, "<X>", 2); len(parts) == 2 {
+				value = parts[1]
+			}
+			fmt.Fprintf(&lines, "%d %v\n", int64(entry.Score), value)
+		}
+
+		offset += int64(len(entries))
+	}
+
+	if dryRun {
+		fmt.Printf("would write %v:\n%v", key, lines.String())
+		return nil
+	}
+
+	filename := shared.CalculateFilename(key, shared.Config.Root)
+	newFile := false
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		newFile = true
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if newFile {
+		if _, err := file.WriteString("v2 " + key + "\n"); err != nil {
+			return err
+		}
+	}
+	_, err = file.WriteString(lines.String())
+	return err
+}
+
+func loadCheckpoint(path string) checkpoint {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return checkpoint{}
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return checkpoint{}
+	}
+	return cp
+}
+
+func saveCheckpoint(path string, cp checkpoint) {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		fmt.Printf("couldn't encode checkpoint: %v\n", err)
+		return
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		fmt.Printf("couldn't save checkpoint %v: %v\n", path, err)
+	}
+}
+
+// recordFailure durably appends a permanently-failed key and its error to
+// path, so it stays visible for later investigation or a manual retry
+// instead of silently vanishing once its checkpoint entry is popped.
+func recordFailure(path, key string, cause error) {
+	line := fmt.Sprintf("%s\t%v\n", key, cause)
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("couldn't record failure for %v: %v\n", key, err)
+		return
+	}
+	defer file.Close()
+	file.WriteString(line)
+}
+
+// newRateLimiter returns a function that blocks just long enough to cap
+// calls at keysPerSecond; a non-positive value disables throttling entirely.
+func newRateLimiter(keysPerSecond int) func() {
+	if keysPerSecond <= 0 {
+		return func() {}
+	}
+
+	interval := time.Second / time.Duration(keysPerSecond)
+	last := time.Now()
+	return func() {
+		if elapsed := time.Since(last); elapsed < interval {
+			time.Sleep(interval - elapsed)
+		}
+		last = time.Now()
+	}
+}
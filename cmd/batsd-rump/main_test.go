@@ -0,0 +1,53 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckpointSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "batsd-rump.cursor")
+
+	want := checkpoint{PatternIndex: 1, Cursor: 42, PendingKeys: []string{"counters:foo", "counters:bar"}}
+	saveCheckpoint(path, want)
+
+	got := loadCheckpoint(path)
+	if got.PatternIndex != want.PatternIndex || got.Cursor != want.Cursor || len(got.PendingKeys) != len(want.PendingKeys) {
+		t.Fatalf("loadCheckpoint() = %+v, want %+v", got, want)
+	}
+	for i, key := range want.PendingKeys {
+		if got.PendingKeys[i] != key {
+			t.Errorf("PendingKeys[%d] = %q, want %q", i, got.PendingKeys[i], key)
+		}
+	}
+}
+
+func TestLoadCheckpointMissingFile(t *testing.T) {
+	got := loadCheckpoint(filepath.Join(t.TempDir(), "does-not-exist"))
+	if got.PatternIndex != 0 || got.Cursor != 0 || len(got.PendingKeys) != 0 {
+		t.Errorf("loadCheckpoint(missing) = %+v, want zero value", got)
+	}
+}
+
+func TestNewRateLimiterThrottles(t *testing.T) {
+	limit := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limit()
+	}
+	if elapsed := time.Since(start); elapsed < 2*(time.Second/100) {
+		t.Errorf("newRateLimiter(100) allowed 3 calls in %v, expected throttling", elapsed)
+	}
+}
+
+func TestNewRateLimiterDisabled(t *testing.T) {
+	limit := newRateLimiter(0)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		limit()
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("newRateLimiter(0) throttled calls, took %v, want effectively instant", elapsed)
+	}
+}
@@ -3,18 +3,22 @@ package main
 import (
 	"../shared"
 	"bufio"
+	"context"
 	"fmt"
 	"net"
-	"os"
-	"path/filepath"
+	"net/http"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
-	"syscall"
+	"strings"
 	"time"
 
-	"github.com/noahhl/Go-Redis"
-	"github.com/reusee/mmh3"
+	"github.com/cespare/xxhash/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Datapoint struct {
@@ -22,6 +26,7 @@ type Datapoint struct {
 	Name      string
 	Value     float64
 	Datatype  string
+	Tags      string
 }
 
 type AggregateObservation struct {
@@ -38,12 +43,67 @@ var redisAppendChannel chan AggregateObservation
 var timerHeartbeat chan int
 var counterHeartbeat chan int
 
-const readLen = 256
+var ctx = context.Background()
+var redisClient redis.UniversalClient
+var slotPicker shared.SlotPicker
+var tsStore shared.TSStore
+
+const readLen = 8192
 const channelBufferSize = 10000
 const heartbeatInterval = 1
+const redisFlushInterval = 50 * time.Millisecond
+const redisFlushSize = 500
+const diskFlushInterval = 10 * time.Millisecond
+const diskFlushSize = 500
+const defaultMetricsAddr = ":8349"
+
+var (
+	datapointsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "batsd_datapoints_total",
+		Help: "Incoming datapoints processed, by type.",
+	}, []string{"type"})
+
+	parseFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "batsd_parse_failures_total",
+		Help: "Packets that failed to parse as a datapoint.",
+	})
+
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "batsd_queue_depth",
+		Help: "Current number of items buffered in a processing channel.",
+	}, []string{"channel"})
+
+	diskAppendLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "batsd_disk_append_latency_seconds",
+		Help: "Latency of one appendToFile batch flush.",
+	})
+
+	redisFlushLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "batsd_redis_flush_latency_seconds",
+		Help: "Latency of a pipelined flush to Redis.",
+	})
+
+	seriesInMemory = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "batsd_series_in_memory",
+		Help: "Distinct series currently held in a retention's slot map.",
+	}, []string{"retention", "datatype"})
+
+	heartbeatLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "batsd_heartbeat_lag_seconds",
+		Help: "Wall time between successive counterHeartbeat sends.",
+	})
+)
 
 func main() {
 	shared.LoadConfig()
+	redisClient = newRedisClient()
+	slotPicker = shared.NewSlotPicker(shared.Config.SlotPicker)
+
+	store, err := shared.NewTSStore(shared.Config.StoreURL)
+	if err != nil {
+		panic(err)
+	}
+	tsStore = store
 	gaugeChannel = make(chan Datapoint, channelBufferSize)
 	counterChannel = make(chan Datapoint, channelBufferSize)
 	timerChannel = make(chan Datapoint, channelBufferSize)
@@ -57,6 +117,9 @@ func main() {
 	diskAppendChannel = appendToFile(datapointChannel)
 	redisAppendChannel = addToRedisZset()
 
+	go startMetricsServer()
+	go reportQueueDepths()
+
 	go runHeartbeat()
 
 	go processGauges(gaugeChannel)
@@ -75,15 +138,41 @@ func main() {
 
 func runHeartbeat() {
 	ticker := time.NewTicker(1 * time.Second)
+	lastTick := time.Now()
 	for {
 		select {
 		case <-ticker.C:
+			now := time.Now()
+			heartbeatLag.Set(now.Sub(lastTick).Seconds())
+			lastTick = now
 			counterHeartbeat <- 1
 			timerHeartbeat <- 1
 		}
 	}
 }
 
+func startMetricsServer() {
+	addr := shared.Config.MetricsAddr
+	if addr == "" {
+		addr = defaultMetricsAddr
+	}
+
+	if err := http.ListenAndServe(addr, promhttp.Handler()); err != nil {
+		fmt.Printf("%v", err)
+	}
+}
+
+func reportQueueDepths() {
+	ticker := time.NewTicker(1 * time.Second)
+	for range ticker.C {
+		queueDepth.WithLabelValues("gauge").Set(float64(len(gaugeChannel)))
+		queueDepth.WithLabelValues("counter").Set(float64(len(counterChannel)))
+		queueDepth.WithLabelValues("timer").Set(float64(len(timerChannel)))
+		queueDepth.WithLabelValues("disk_append").Set(float64(len(diskAppendChannel)))
+		queueDepth.WithLabelValues("redis_append").Set(float64(len(redisAppendChannel)))
+	}
+}
+
 func bindUDP() {
 
 	server, err := net.ListenPacket("udp", ":"+shared.Config.Port)
@@ -98,7 +187,11 @@ func bindUDP() {
 		if err != nil {
 			continue
 		}
-		processIncomingMessage(string(buffer[0:n]))
+		for _, message := range strings.Split(string(buffer[0:n]), "\n") {
+			if message != "" {
+				processIncomingMessage(message)
+			}
+		}
 	}
 }
 
@@ -141,38 +234,114 @@ func clientTCPConns(listener net.Listener) chan net.Conn {
 func processIncomingMessage(message string) {
 	d := parseDatapoint(message)
 	if d.Datatype == "g" {
+		datapointsTotal.WithLabelValues("g").Inc()
 		gaugeChannel <- d
 	} else if d.Datatype == "c" {
+		datapointsTotal.WithLabelValues("c").Inc()
 		counterChannel <- d
 	} else if d.Datatype == "ms" {
+		datapointsTotal.WithLabelValues("ms").Inc()
 		timerChannel <- d
+	} else {
+		parseFailuresTotal.Inc()
 	}
 
 }
 
 func parseDatapoint(metric string) Datapoint {
-	metricRegex, err := regexp.Compile("(.*):([0-9|\\.]+)\\|(c|g|ms)")
+	metricRegex, err := regexp.Compile("(.*):([0-9|\\.]+)\\|(c|g|ms)(?:\\|@([0-9.]+))?(?:\\|#([^|]+))?")
 	if err != nil {
 		fmt.Printf("%v", err)
 	}
-	matches := metricRegex.FindAllStringSubmatch(metric, -1)
+	matches := metricRegex.FindAllStringSubmatch(strings.TrimSpace(metric), -1)
 	d := Datapoint{}
-	if len(matches) > 0 && len(matches[0]) == 4 {
+	if len(matches) > 0 && len(matches[0]) == 6 {
 		value, _ := strconv.ParseFloat(matches[0][2], 64)
-		d = Datapoint{time.Now(), matches[0][1], value, matches[0][3]}
+		datatype := matches[0][3]
+
+		if rawRate := matches[0][4]; rawRate != "" {
+			if rate, err := strconv.ParseFloat(rawRate, 64); err == nil && rate > 0 && rate <= 1 && datatype == "c" {
+				value = value / rate
+			}
+		}
+
+		d = Datapoint{time.Now(), matches[0][1], value, datatype, canonicalizeTags(matches[0][5])}
 	}
 	return d
 }
 
+// canonicalizeTags turns "tag1:v1,tag2:v2" into sorted, lowercased-key "tag1=v1;tag2=v2".
+func canonicalizeTags(raw string) string {
+	if raw == "" {
+		return ""
+	}
+
+	pairs := strings.Split(raw, ",")
+	canon := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, ":", 2)
+		key := strings.ToLower(kv[0])
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		canon = append(canon, key+"="+value)
+	}
+	sort.Strings(canon)
+
+	return strings.Join(canon, ";")
+}
+
+func aggregationKey(name, tags string) string {
+	if tags == "" {
+		return name
+	}
+	return name + ";" + tags
+}
+
+func newRedisClient() redis.UniversalClient {
+	client, err := shared.NewRedisClient(shared.Config.RedisURL)
+	if err != nil {
+		panic(err)
+	}
+	return client
+}
+
 func saveNewDatapoints() chan string {
 	c := make(chan string, channelBufferSize)
 
 	go func(ch chan string) {
-		spec := redis.DefaultSpec().Host(shared.Config.RedisHost).Port(shared.Config.RedisPort)
-		redis, _ := redis.NewSynchClientWithSpec(spec)
+		batch := make([]string, 0, redisFlushSize)
+		ticker := time.NewTicker(redisFlushInterval)
+
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			pipe := redisClient.Pipeline()
+			for _, d := range batch {
+				pipe.SAdd(ctx, "datapoints", d)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				fmt.Printf("%v", err)
+			}
+			batch = batch[:0]
+		}
+
 		for {
-			d := <-ch
-			redis.Sadd("datapoints", []byte(d))
+			select {
+			case d := <-ch:
+				batch = append(batch, d)
+				if len(batch) >= redisFlushSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
 		}
 	}(c)
 
@@ -183,39 +352,62 @@ func appendToFile(datapoints chan string) chan AggregateObservation {
 	c := make(chan AggregateObservation, channelBufferSize)
 
 	go func(ch chan AggregateObservation, datapoints chan string) {
-		for {
-			observation := <-ch
-			filename := shared.CalculateFilename(observation.Name, shared.Config.Root)
-
-			file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
-			newFile := false
-			if err != nil {
-				if e, ok := err.(*os.PathError); ok && e.Err == syscall.ENOENT {
-					fmt.Printf("Creating %v\n", filename)
-					//Make containing directories if they don't exist
-					err = os.MkdirAll(filepath.Dir(filename), 0755)
-					if err != nil {
-						fmt.Printf("%v", err)
-					}
+		batch := make([]shared.TSEntry, 0, diskFlushSize)
+		ticker := time.NewTicker(diskFlushInterval)
 
-					file, err = os.Create(filename)
-					if err != nil {
-						fmt.Printf("%v", err)
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			start := time.Now()
+
+			// Re-check Create every flush instead of caching forever, so a
+			// file removed out from under the store gets recreated.
+			seenThisFlush := make(map[string]bool, len(batch))
+			for _, entry := range batch {
+				if seenThisFlush[entry.Series] {
+					continue
+				}
+				seenThisFlush[entry.Series] = true
+				created, err := tsStore.Create(entry.Series)
+				if err != nil {
+					fmt.Printf("failed to create %v: %v\n", entry.Series, err)
+					continue
+				}
+				if created {
+					datapoints <- entry.Series
+				}
+			}
+
+			if batcher, ok := tsStore.(shared.BatchTSStore); ok {
+				if err := batcher.AppendBatch(batch); err != nil {
+					fmt.Printf("failed to append batch: %v\n", err)
+				}
+			} else {
+				for _, entry := range batch {
+					if err := tsStore.Append(entry.Series, entry.Timestamp, entry.Payload); err != nil {
+						fmt.Printf("failed to append %v: %v\n", entry.Series, err)
 					}
-					newFile = true
-					datapoints <- observation.Name
-				} else {
-					panic(err)
 				}
 			}
-			if file != nil {
-				writer := bufio.NewWriter(file)
-				if newFile {
-					writer.WriteString("v2 " + observation.Name + "\n")
+
+			diskAppendLatency.Observe(time.Since(start).Seconds())
+			batch = batch[:0]
+		}
+
+		for {
+			select {
+			case observation := <-ch:
+				batch = append(batch, shared.TSEntry{
+					Series:    observation.Name,
+					Timestamp: observation.Timestamp,
+					Payload:   []byte(observation.Content),
+				})
+				if len(batch) >= diskFlushSize {
+					flush()
 				}
-				writer.WriteString(observation.Content)
-				writer.Flush()
-				file.Close()
+			case <-ticker.C:
+				flush()
 			}
 		}
 	}(c, datapoints)
@@ -225,11 +417,35 @@ func appendToFile(datapoints chan string) chan AggregateObservation {
 func addToRedisZset() chan AggregateObservation {
 	c := make(chan AggregateObservation, channelBufferSize)
 	go func(ch chan AggregateObservation) {
-		spec := redis.DefaultSpec().Host(shared.Config.RedisHost).Port(shared.Config.RedisPort)
-		redis, _ := redis.NewSynchClientWithSpec(spec)
+		batch := make([]AggregateObservation, 0, redisFlushSize)
+		ticker := time.NewTicker(redisFlushInterval)
+
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			start := time.Now()
+			pipe := redisClient.Pipeline()
+			for _, observation := range batch {
+				pipe.ZAdd(ctx, observation.Name, &redis.Z{Score: float64(observation.Timestamp), Member: observation.Content})
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				fmt.Printf("%v", err)
+			}
+			redisFlushLatency.Observe(time.Since(start).Seconds())
+			batch = batch[:0]
+		}
+
 		for {
-			observation := <-ch
-			redis.Zadd(observation.Name, float64(observation.Timestamp), []byte(observation.Content))
+			select {
+			case observation := <-ch:
+				batch = append(batch, observation)
+				if len(batch) >= redisFlushSize {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
 		}
 	}(c)
 
@@ -241,7 +457,7 @@ func processGauges(gauges chan Datapoint) {
 	for {
 		d := <-gauges
 		//fmt.Printf("Processing gauge %v with value %v and timestamp %v \n", d.Name, d.Value, d.Timestamp)
-		observation := AggregateObservation{"gauges:" + d.Name, fmt.Sprintf("%d %v\n", d.Timestamp.Unix(), d.Value), 0}
+		observation := AggregateObservation{"gauges:" + aggregationKey(d.Name, d.Tags), fmt.Sprintf("%d %v\n", d.Timestamp.Unix(), d.Value), d.Timestamp.Unix()}
 		diskAppendChannel <- observation
 	}
 }
@@ -271,9 +487,11 @@ func processCounters(ch chan Datapoint) {
 		select {
 		case d := <-ch:
 			//fmt.Printf("Processing counter %v with value %v and timestamp %v \n", d.Name, d.Value, d.Timestamp)
+			key := aggregationKey(d.Name, d.Tags)
+			nameHash := xxhash.Sum64String(key)
 			for i := range shared.Config.Retentions {
-				hashSlot := int64(mmh3.Hash32([]byte(d.Name))) % maxSlots[i]
-				counters[i][hashSlot][d.Name] += d.Value
+				hashSlot := slotPicker.Pick(nameHash, maxSlots[i])
+				counters[i][hashSlot][key] += d.Value
 			}
 
 		case <-counterHeartbeat:
@@ -296,6 +514,12 @@ func processCounters(ch chan Datapoint) {
 				if currentSlots[i] == maxSlots[i] {
 					currentSlots[i] = 0
 				}
+
+				seriesCount := 0
+				for _, slot := range counters[i] {
+					seriesCount += len(slot)
+				}
+				seriesInMemory.WithLabelValues(strconv.FormatInt(shared.Config.Retentions[i].Interval, 10), "counter").Set(float64(seriesCount))
 			}
 		}
 	}
@@ -322,9 +546,11 @@ func processTimers(ch chan Datapoint) {
 		select {
 		case d := <-ch:
 			//fmt.Printf("Processing timer %v with value %v and timestamp %v \n", d.Name, d.Value, d.Timestamp)
+			key := aggregationKey(d.Name, d.Tags)
+			nameHash := xxhash.Sum64String(key)
 			for i := range shared.Config.Retentions {
-				hashSlot := int64(mmh3.Hash32([]byte(d.Name))) % maxSlots[i]
-				timers[i][hashSlot][d.Name] = append(timers[i][hashSlot][d.Name], d.Value)
+				hashSlot := slotPicker.Pick(nameHash, maxSlots[i])
+				timers[i][hashSlot][key] = append(timers[i][hashSlot][key], d.Value)
 			}
 		case <-timerHeartbeat:
 			for i := range currentSlots {
@@ -361,6 +587,12 @@ func processTimers(ch chan Datapoint) {
 				if currentSlots[i] == maxSlots[i] {
 					currentSlots[i] = 0
 				}
+
+				seriesCount := 0
+				for _, slot := range timers[i] {
+					seriesCount += len(slot)
+				}
+				seriesInMemory.WithLabelValues(strconv.FormatInt(shared.Config.Retentions[i].Interval, 10), "timer").Set(float64(seriesCount))
 			}
 
 		}
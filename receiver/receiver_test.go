@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCanonicalizeTags(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"env:prod", "env=prod"},
+		{"Env:prod,Host:web1", "env=prod;host=web1"},
+		{"b:2,a:1", "a=1;b=2"},
+		{"solo", "solo="},
+	}
+
+	for _, c := range cases {
+		if got := canonicalizeTags(c.raw); got != c.want {
+			t.Errorf("canonicalizeTags(%q) = %q, want %q", c.raw, got, c.want)
+		}
+	}
+}
+
+func TestAggregationKey(t *testing.T) {
+	if got := aggregationKey("req.count", ""); got != "req.count" {
+		t.Errorf("aggregationKey with no tags = %q, want %q", got, "req.count")
+	}
+	if got := aggregationKey("req.count", "env=prod"); got != "req.count;env=prod" {
+		t.Errorf("aggregationKey with tags = %q, want %q", got, "req.count;env=prod")
+	}
+}
+
+func TestParseDatapoint(t *testing.T) {
+	cases := []struct {
+		metric       string
+		wantName     string
+		wantValue    float64
+		wantDatatype string
+		wantTags     string
+	}{
+		{"req.count:1|c", "req.count", 1, "c", ""},
+		{"req.latency:42.5|ms", "req.latency", 42.5, "ms", ""},
+		{"req.inflight:5|g", "req.inflight", 5, "g", ""},
+		{"req.count:1|c|#env:prod,host:web1", "req.count", 1, "c", "env=prod;host=web1"},
+		{"req.count:1|c|@0.1", "req.count", 10, "c", ""},
+	}
+
+	for _, c := range cases {
+		d := parseDatapoint(c.metric)
+		if d.Name != c.wantName || d.Value != c.wantValue || d.Datatype != c.wantDatatype || d.Tags != c.wantTags {
+			t.Errorf("parseDatapoint(%q) = %+v, want {Name:%q Value:%v Datatype:%q Tags:%q}",
+				c.metric, d, c.wantName, c.wantValue, c.wantDatatype, c.wantTags)
+		}
+	}
+}
+
+func TestParseDatapointInvalid(t *testing.T) {
+	d := parseDatapoint("not a valid metric")
+	if d.Datatype != "" {
+		t.Errorf("parseDatapoint(invalid) = %+v, want zero value", d)
+	}
+}
+
+func TestProcessIncomingMessageMetrics(t *testing.T) {
+	counterChannel = make(chan Datapoint, 1)
+
+	before := testutil.ToFloat64(datapointsTotal.WithLabelValues("c"))
+	processIncomingMessage("req.count:1|c")
+	after := testutil.ToFloat64(datapointsTotal.WithLabelValues("c"))
+	if after != before+1 {
+		t.Errorf("datapointsTotal{type=c} = %v, want %v", after, before+1)
+	}
+
+	select {
+	case d := <-counterChannel:
+		if d.Name != "req.count" {
+			t.Errorf("counterChannel received %+v, want Name=req.count", d)
+		}
+	default:
+		t.Fatal("processIncomingMessage did not send the parsed datapoint to counterChannel")
+	}
+}
+
+func TestProcessIncomingMessageParseFailure(t *testing.T) {
+	before := testutil.ToFloat64(parseFailuresTotal)
+	processIncomingMessage("garbage")
+	after := testutil.ToFloat64(parseFailuresTotal)
+	if after != before+1 {
+		t.Errorf("parseFailuresTotal = %v, want %v", after, before+1)
+	}
+}
@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FileTSStore is the original one-file-per-series v2 format, wrapped behind TSStore.
+type FileTSStore struct {
+	root string
+}
+
+func NewFileTSStore(root string) *FileTSStore {
+	return &FileTSStore{root: root}
+}
+
+func (s *FileTSStore) Create(series string) (bool, error) {
+	filename := CalculateFilename(series, s.root)
+	if _, err := os.Stat(filename); err == nil {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return false, err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString("v2 " + series + "\n")
+	return err == nil, err
+}
+
+func (s *FileTSStore) Append(series string, ts int64, payload []byte) error {
+	filename := CalculateFilename(series, s.root)
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(payload)
+	return err
+}
+
+func (s *FileTSStore) Range(series string, from, to int64) (TSIterator, error) {
+	filename := CalculateFilename(series, s.root)
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileTSIterator{file: file, scanner: bufio.NewScanner(file), from: from, to: to}, nil
+}
+
+func (s *FileTSStore) Close() error {
+	return nil
+}
+
+type fileTSIterator struct {
+	file     *os.File
+	scanner  *bufio.Scanner
+	from, to int64
+	ts       int64
+	payload  []byte
+}
+
+func (it *fileTSIterator) Next() bool {
+	for it.scanner.Scan() {
+		line := it.scanner.Text()
+		if strings.HasPrefix(line, "v2 ") {
+			continue
+		}
+
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		ts, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || ts < it.from || ts > it.to {
+			continue
+		}
+
+		it.ts = ts
+		it.payload = []byte(parts[1])
+		return true
+	}
+	return false
+}
+
+func (it *fileTSIterator) Timestamp() int64 {
+	return it.ts
+}
+
+func (it *fileTSIterator) Payload() []byte {
+	return it.payload
+}
+
+func (it *fileTSIterator) Close() error {
+	return it.file.Close()
+}
@@ -0,0 +1,55 @@
+package shared
+
+import (
+	"testing"
+)
+
+func TestFileTSStoreAppendRangeRoundTrip(t *testing.T) {
+	store := NewFileTSStore(t.TempDir())
+	series := "counters.req.count"
+
+	created, err := store.Create(series)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if !created {
+		t.Errorf("Create(%q) reported created=false on first call", series)
+	}
+
+	// A second Create should be a no-op and report created=false.
+	created, err = store.Create(series)
+	if err != nil {
+		t.Fatalf("second Create: %v", err)
+	}
+	if created {
+		t.Errorf("second Create(%q) reported created=true, want false", series)
+	}
+
+	want := []struct {
+		ts      int64
+		payload string
+	}{
+		{100, "100 1\n"},
+		{200, "200 2\n"},
+		{300, "300 3\n"},
+	}
+	for _, w := range want {
+		if err := store.Append(series, w.ts, []byte(w.payload)); err != nil {
+			t.Fatalf("Append(%d): %v", w.ts, err)
+		}
+	}
+
+	it, err := store.Range(series, 150, 300)
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	defer it.Close()
+
+	var got []int64
+	for it.Next() {
+		got = append(got, it.Timestamp())
+	}
+	if len(got) != 2 || got[0] != 200 || got[1] != 300 {
+		t.Errorf("Range(150, 300) returned timestamps %v, want [200 300]", got)
+	}
+}
@@ -0,0 +1,95 @@
+package shared
+
+import (
+	"encoding/binary"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// LevelDBTSStore keeps every series in one shared LevelDB handle, keyed as "series\x00<big-endian ts>".
+type LevelDBTSStore struct {
+	db *leveldb.DB
+}
+
+func NewLevelDBTSStore(path string) (*LevelDBTSStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDBTSStore{db: db}, nil
+}
+
+func tsKey(series string, ts int64) []byte {
+	key := make([]byte, len(series)+1+8)
+	copy(key, series)
+	binary.BigEndian.PutUint64(key[len(series)+1:], uint64(ts))
+	return key
+}
+
+func seriesMetaKey(series string) []byte {
+	return append([]byte("\x01meta\x00"), series...)
+}
+
+func (s *LevelDBTSStore) Create(series string) (bool, error) {
+	key := seriesMetaKey(series)
+	exists, err := s.db.Has(key, nil)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, nil
+	}
+	if err := s.db.Put(key, nil, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *LevelDBTSStore) Append(series string, ts int64, payload []byte) error {
+	return s.db.Put(tsKey(series, ts), payload, nil)
+}
+
+// AppendBatch writes every entry through a single leveldb.Batch.
+func (s *LevelDBTSStore) AppendBatch(entries []TSEntry) error {
+	batch := new(leveldb.Batch)
+	for _, entry := range entries {
+		batch.Put(tsKey(entry.Series, entry.Timestamp), entry.Payload)
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *LevelDBTSStore) Range(series string, from, to int64) (TSIterator, error) {
+	rng := &util.Range{Start: tsKey(series, from), Limit: tsKey(series, to+1)}
+	return &levelDBTSIterator{iter: s.db.NewIterator(rng, nil), series: series}, nil
+}
+
+func (s *LevelDBTSStore) Close() error {
+	return s.db.Close()
+}
+
+type levelDBTSIterator struct {
+	iter   iterator.Iterator
+	series string
+}
+
+func (it *levelDBTSIterator) Next() bool {
+	return it.iter.Next()
+}
+
+func (it *levelDBTSIterator) Timestamp() int64 {
+	key := it.iter.Key()
+	return int64(binary.BigEndian.Uint64(key[len(it.series)+1:]))
+}
+
+func (it *levelDBTSIterator) Payload() []byte {
+	payload := make([]byte, len(it.iter.Value()))
+	copy(payload, it.iter.Value())
+	return payload
+}
+
+func (it *levelDBTSIterator) Close() error {
+	it.iter.Release()
+	return it.iter.Error()
+}
@@ -0,0 +1,66 @@
+package shared
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLevelDBTSStoreAppendRangeRoundTrip(t *testing.T) {
+	store, err := NewLevelDBTSStore(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("NewLevelDBTSStore: %v", err)
+	}
+	defer store.Close()
+
+	series := "timers.req.latency"
+	for ts, payload := range map[int64]string{100: "a", 200: "b", 300: "c"} {
+		if err := store.Append(series, ts, []byte(payload)); err != nil {
+			t.Fatalf("Append(%d): %v", ts, err)
+		}
+	}
+
+	it, err := store.Range(series, 150, 300)
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	defer it.Close()
+
+	var got []int64
+	for it.Next() {
+		got = append(got, it.Timestamp())
+	}
+	if len(got) != 2 || got[0] != 200 || got[1] != 300 {
+		t.Errorf("Range(150, 300) returned timestamps %v, want [200 300]", got)
+	}
+}
+
+func TestLevelDBTSStoreAppendBatch(t *testing.T) {
+	store, err := NewLevelDBTSStore(filepath.Join(t.TempDir(), "db"))
+	if err != nil {
+		t.Fatalf("NewLevelDBTSStore: %v", err)
+	}
+	defer store.Close()
+
+	entries := []TSEntry{
+		{Series: "counters.a", Timestamp: 1, Payload: []byte("x")},
+		{Series: "counters.a", Timestamp: 2, Payload: []byte("y")},
+		{Series: "counters.b", Timestamp: 1, Payload: []byte("z")},
+	}
+	if err := store.AppendBatch(entries); err != nil {
+		t.Fatalf("AppendBatch: %v", err)
+	}
+
+	it, err := store.Range("counters.a", 0, 10)
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	defer it.Close()
+
+	var count int
+	for it.Next() {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("Range over counters.a returned %d entries, want 2", count)
+	}
+}
@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// NewRedisClient builds a client from a redis://, redis+sentinel:// or redis+cluster:// URI.
+func NewRedisClient(uri string) (redis.UniversalClient, error) {
+	switch {
+	case strings.HasPrefix(uri, "redis+sentinel://"):
+		addrs, db, master := ParseSentinelURL(uri)
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    master,
+			SentinelAddrs: addrs,
+			DB:            db,
+			PoolSize:      Config.RedisPoolSize,
+			MinIdleConns:  Config.RedisMinIdleConns,
+			ReadTimeout:   Config.RedisReadTimeout,
+			WriteTimeout:  Config.RedisWriteTimeout,
+		}), nil
+	case strings.HasPrefix(uri, "redis+cluster://"):
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        ParseClusterURL(uri),
+			PoolSize:     Config.RedisPoolSize,
+			MinIdleConns: Config.RedisMinIdleConns,
+			ReadTimeout:  Config.RedisReadTimeout,
+			WriteTimeout: Config.RedisWriteTimeout,
+		}), nil
+	default:
+		opts, err := redis.ParseURL(uri)
+		if err != nil {
+			return nil, err
+		}
+		opts.PoolSize = Config.RedisPoolSize
+		opts.MinIdleConns = Config.RedisMinIdleConns
+		opts.ReadTimeout = Config.RedisReadTimeout
+		opts.WriteTimeout = Config.RedisWriteTimeout
+		return redis.NewClient(opts), nil
+	}
+}
+
+func ParseSentinelURL(uri string) ([]string, int, string) {
+	rest := strings.TrimPrefix(uri, "redis+sentinel://")
+	parts := strings.SplitN(rest, "/", 3)
+
+	addrs := strings.Split(parts[0], ",")
+	master := "mymaster"
+	db := 0
+	if len(parts) > 1 && parts[1] != "" {
+		master = parts[1]
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		db, _ = strconv.Atoi(parts[2])
+	}
+	return addrs, db, master
+}
+
+func ParseClusterURL(uri string) []string {
+	rest := strings.TrimPrefix(uri, "redis+cluster://")
+	rest = strings.SplitN(rest, "/", 2)[0]
+	return strings.Split(rest, ",")
+}
@@ -0,0 +1,55 @@
+package shared
+
+import "testing"
+
+func TestParseSentinelURL(t *testing.T) {
+	cases := []struct {
+		uri        string
+		wantAddrs  []string
+		wantDB     int
+		wantMaster string
+	}{
+		{"redis+sentinel://host1:26379,host2:26379", []string{"host1:26379", "host2:26379"}, 0, "mymaster"},
+		{"redis+sentinel://host1:26379/mymaster", []string{"host1:26379"}, 0, "mymaster"},
+		{"redis+sentinel://host1:26379/prod/2", []string{"host1:26379"}, 2, "prod"},
+		{"redis+sentinel://host1:26379/", []string{"host1:26379"}, 0, "mymaster"},
+	}
+
+	for _, c := range cases {
+		addrs, db, master := ParseSentinelURL(c.uri)
+		if len(addrs) != len(c.wantAddrs) || db != c.wantDB || master != c.wantMaster {
+			t.Errorf("ParseSentinelURL(%q) = (%v, %d, %q), want (%v, %d, %q)",
+				c.uri, addrs, db, master, c.wantAddrs, c.wantDB, c.wantMaster)
+			continue
+		}
+		for i, addr := range addrs {
+			if addr != c.wantAddrs[i] {
+				t.Errorf("ParseSentinelURL(%q) addrs[%d] = %q, want %q", c.uri, i, addr, c.wantAddrs[i])
+			}
+		}
+	}
+}
+
+func TestParseClusterURL(t *testing.T) {
+	cases := []struct {
+		uri  string
+		want []string
+	}{
+		{"redis+cluster://host1:6379,host2:6379,host3:6379", []string{"host1:6379", "host2:6379", "host3:6379"}},
+		{"redis+cluster://host1:6379/", []string{"host1:6379"}},
+		{"redis+cluster://host1:6379", []string{"host1:6379"}},
+	}
+
+	for _, c := range cases {
+		got := ParseClusterURL(c.uri)
+		if len(got) != len(c.want) {
+			t.Errorf("ParseClusterURL(%q) = %v, want %v", c.uri, got, c.want)
+			continue
+		}
+		for i, addr := range got {
+			if addr != c.want[i] {
+				t.Errorf("ParseClusterURL(%q)[%d] = %q, want %q", c.uri, i, addr, c.want[i])
+			}
+		}
+	}
+}
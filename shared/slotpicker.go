@@ -0,0 +1,56 @@
+package shared
+
+import (
+	"encoding/binary"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// SlotPicker assigns a metric name's precomputed hash to one of maxSlots slots.
+type SlotPicker interface {
+	Pick(nameHash uint64, maxSlots int64) int64
+}
+
+// ModuloSlotPicker assigns slots with nameHash % maxSlots.
+type ModuloSlotPicker struct{}
+
+func (ModuloSlotPicker) Pick(nameHash uint64, maxSlots int64) int64 {
+	if maxSlots <= 0 {
+		return 0
+	}
+	return int64(nameHash % uint64(maxSlots))
+}
+
+// RendezvousSlotPicker assigns slots with rendezvous (HRW) hashing, so a
+// maxSlots change only reshuffles names whose winning slot was added/removed.
+type RendezvousSlotPicker struct{}
+
+func (RendezvousSlotPicker) Pick(nameHash uint64, maxSlots int64) int64 {
+	if maxSlots <= 0 {
+		return 0
+	}
+
+	var best int64
+	var bestScore uint64
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[0:8], nameHash)
+
+	for slot := int64(0); slot < maxSlots; slot++ {
+		binary.BigEndian.PutUint64(buf[8:16], uint64(slot))
+		if score := xxhash.Sum64(buf[:]); score > bestScore || slot == 0 {
+			bestScore = score
+			best = slot
+		}
+	}
+	return best
+}
+
+// NewSlotPicker selects a SlotPicker by name ("modulo" or "rendezvous"), defaulting to modulo.
+func NewSlotPicker(kind string) SlotPicker {
+	switch kind {
+	case "rendezvous":
+		return RendezvousSlotPicker{}
+	default:
+		return ModuloSlotPicker{}
+	}
+}
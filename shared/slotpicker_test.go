@@ -0,0 +1,41 @@
+package shared
+
+import "testing"
+
+func TestModuloSlotPicker(t *testing.T) {
+	var p ModuloSlotPicker
+	if got := p.Pick(10, 4); got != 2 {
+		t.Errorf("Pick(10, 4) = %d, want 2", got)
+	}
+	if got := p.Pick(10, 0); got != 0 {
+		t.Errorf("Pick(10, 0) = %d, want 0", got)
+	}
+}
+
+func TestRendezvousSlotPickerStable(t *testing.T) {
+	var p RendezvousSlotPicker
+	const nameHash = 123456789
+
+	first := p.Pick(nameHash, 100)
+	for i := 0; i < 10; i++ {
+		if got := p.Pick(nameHash, 100); got != first {
+			t.Errorf("Pick(%d, 100) = %d on call %d, want stable %d", nameHash, got, i, first)
+		}
+	}
+
+	if got := p.Pick(nameHash, 0); got != 0 {
+		t.Errorf("Pick(nameHash, 0) = %d, want 0", got)
+	}
+}
+
+func TestNewSlotPicker(t *testing.T) {
+	if _, ok := NewSlotPicker("rendezvous").(RendezvousSlotPicker); !ok {
+		t.Errorf("NewSlotPicker(\"rendezvous\") did not return a RendezvousSlotPicker")
+	}
+	if _, ok := NewSlotPicker("modulo").(ModuloSlotPicker); !ok {
+		t.Errorf("NewSlotPicker(\"modulo\") did not return a ModuloSlotPicker")
+	}
+	if _, ok := NewSlotPicker("bogus").(ModuloSlotPicker); !ok {
+		t.Errorf("NewSlotPicker(\"bogus\") did not default to ModuloSlotPicker")
+	}
+}
@@ -0,0 +1,47 @@
+package shared
+
+import "strings"
+
+// TSEntry is one observation destined for a TSStore.
+type TSEntry struct {
+	Series    string
+	Timestamp int64
+	Payload   []byte
+}
+
+// TSStore is a pluggable time-series storage backend for appended observations.
+type TSStore interface {
+	Append(series string, ts int64, payload []byte) error
+	Range(series string, from, to int64) (TSIterator, error)
+	// Create ensures series exists, creating it if necessary, and reports
+	// whether it was newly created (vs. already present).
+	Create(series string) (created bool, err error)
+	Close() error
+}
+
+// BatchTSStore is implemented by backends that can batch many Append calls into one write.
+type BatchTSStore interface {
+	AppendBatch(entries []TSEntry) error
+}
+
+// TSIterator walks a Range result in ascending timestamp order.
+type TSIterator interface {
+	Next() bool
+	Timestamp() int64
+	Payload() []byte
+	Close() error
+}
+
+// NewTSStore builds a TSStore from a URI: "leveldb://<path>" selects LevelDBTSStore,
+// anything else is a root directory for the per-file v2 format.
+func NewTSStore(uri string) (TSStore, error) {
+	if strings.HasPrefix(uri, "leveldb://") {
+		return NewLevelDBTSStore(strings.TrimPrefix(uri, "leveldb://"))
+	}
+
+	root := uri
+	if root == "" {
+		root = Config.Root
+	}
+	return NewFileTSStore(root), nil
+}